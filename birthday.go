@@ -6,86 +6,107 @@ import (
 	"time"
 
 	"github.com/SimonSchneider/goslu/date"
+	"github.com/emersion/go-vcard"
+	"github.com/rs/zerolog"
 )
 
 type Birthday struct {
 	UID      string
 	Date     date.Date
 	FullName string
+	// HasYear is false when the source vCard only carries a partial date
+	// (e.g. BDAY:--0315), which is common when the year is unknown.
+	HasYear bool
 }
 
-func parseBirthdayVCard(vcard string) *Birthday {
-	lines := strings.Split(vcard, "\n")
-	var name, fullName, uid string
-	var birthdayDate *time.Time
+// noYearPlaceholder is the year substituted for year-less birthdays so they
+// can still be represented as a date.Date. It must be a leap year so that
+// February 29th birthdays round-trip correctly.
+const noYearPlaceholder = 2000
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+func birthdayFromCard(card vcard.Card, logger zerolog.Logger) *Birthday {
+	uid := card.PreferredValue(vcard.FieldUID)
 
-		// Parse N (Name components)
-		if strings.HasPrefix(line, "N:") {
-			name = strings.TrimPrefix(line, "N:")
-		}
+	bday := card.Get(vcard.FieldBirthday)
+	if bday == nil {
+		return nil
+	}
 
-		// Parse FN (Full Name)
-		if strings.HasPrefix(line, "FN:") {
-			fullName = strings.TrimPrefix(line, "FN:")
-		}
-		if strings.HasPrefix(line, "UID:") {
-			uid = strings.TrimPrefix(line, "UID:")
-		}
+	birthdayDate, hasYear, ok := parseBirthdayValue(bday)
+	if !ok {
+		logger.Debug().
+			Str("uid", uid).
+			Str("field", vcard.FieldBirthday).
+			Str("value", bday.Value).
+			Msg("failed to parse birthday date")
+		return nil
+	}
 
-		// Parse BDAY (Birthday) - handle both BDAY: and BDAY;VALUE=date: formats
-		if strings.HasPrefix(line, "BDAY") {
-			var bdayStr string
-
-			// Handle BDAY;VALUE=date: format
-			if strings.Contains(line, ";VALUE=date:") {
-				parts := strings.Split(line, ";VALUE=date:")
-				if len(parts) == 2 {
-					bdayStr = parts[1]
-				}
-			} else if strings.HasPrefix(line, "BDAY:") {
-				// Handle simple BDAY: format
-				bdayStr = strings.TrimPrefix(line, "BDAY:")
-			}
-
-			if bdayStr != "" {
-				// Remove timezone info if present
-				if idx := strings.Index(bdayStr, "T"); idx != -1 {
-					bdayStr = bdayStr[:idx]
-				}
-
-				// Try different date formats
-				formats := []string{"20060102", "2006-01-02", "2006/01/02"}
-				for _, format := range formats {
-					if date, err := time.Parse(format, bdayStr); err == nil {
-						birthdayDate = &date
-						break
-					}
-				}
-				if birthdayDate == nil {
-					fmt.Printf("failed to parse birthday date: %s\n", bdayStr)
-				}
-			}
-		}
+	displayName := card.PreferredValue(vcard.FieldFormattedName)
+	if displayName == "" {
+		displayName = formatName(card.PreferredValue(vcard.FieldName))
+	}
+	if displayName == "" {
+		return nil
+	}
+
+	return &Birthday{
+		UID:      uid,
+		FullName: displayName,
+		Date:     date.FromTime(birthdayDate),
+		HasYear:  hasYear,
+	}
+}
+
+// parseBirthdayValue parses a BDAY field value, honoring both vCard 3.0's
+// BDAY;VALUE=date: form and vCard 4.0's support for partial dates such as
+// "--0315" (month and day only, year unknown). The returned bool reports
+// whether a year was present.
+func parseBirthdayValue(field *vcard.Field) (result time.Time, hasYear bool, ok bool) {
+	value := strings.TrimSpace(field.Value)
+	if value == "" {
+		return time.Time{}, false, false
+	}
+	if valueType := field.Params.Get(vcard.ParamValue); strings.EqualFold(valueType, "text") {
+		// A free-text birthday (e.g. "circa 1990") can't be turned into a date.
+		return time.Time{}, false, false
+	}
+	if idx := strings.Index(value, "T"); idx != -1 {
+		value = value[:idx]
 	}
 
-	// Only return birthday if we have both name and date
-	if birthdayDate != nil && (name != "" || fullName != "") {
-		displayName := fullName
-		if displayName == "" {
-			displayName = name
+	if strings.HasPrefix(value, "--") {
+		monthDay := strings.ReplaceAll(strings.TrimPrefix(value, "--"), "-", "")
+		if t, err := time.Parse("0102", monthDay); err == nil {
+			return time.Date(noYearPlaceholder, t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), false, true
 		}
+		return time.Time{}, false, false
+	}
 
-		return &Birthday{
-			UID:      uid,
-			FullName: displayName,
-			Date:     date.FromTime(*birthdayDate),
+	for _, format := range []string{"20060102", "2006-01-02", "2006/01/02"} {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, true, true
 		}
 	}
+	return time.Time{}, false, false
+}
 
-	return nil
+// formatName turns a structured N value ("Family;Given;Additional;Prefix;Suffix")
+// into a "Given Family" display name, used when FN is missing.
+func formatName(n string) string {
+	parts := strings.Split(n, ";")
+	for len(parts) < 2 {
+		parts = append(parts, "")
+	}
+	family, given := parts[0], parts[1]
+	switch {
+	case given != "" && family != "":
+		return given + " " + family
+	case given != "":
+		return given
+	default:
+		return family
+	}
 }
 
 func generateBirthdayIcs(birthdays []Birthday, today date.Date) string {
@@ -103,8 +124,8 @@ func generateBirthdayIcs(birthdays []Birthday, today date.Date) string {
 		sb.WriteString("UID:" + fmt.Sprintf("%s-birthday-%d", strings.ReplaceAll(birthday.FullName, " ", ""), birthday.Date.ToStdTime().Year()) + "\r\n")
 		sb.WriteString("DTSTART;VALUE=DATE:" + birthday.Date.ToStdTime().Format("20060102") + "\r\n")
 		sb.WriteString("RRULE:FREQ=YEARLY\r\n")
-		sb.WriteString("SUMMARY:" + birthday.FullName + "'s Birthday\r\n")
-		sb.WriteString("DESCRIPTION:" + birthday.FullName + " born on " + birthday.Date.String() + "\r\n")
+		sb.WriteString("SUMMARY:" + birthdaySummary(birthday) + "\r\n")
+		sb.WriteString("DESCRIPTION:" + birthdayDescription(birthday) + "\r\n")
 		sb.WriteString("TRANSP:TRANSPARENT\r\n")
 		sb.WriteString("END:VEVENT\r\n")
 	}
@@ -114,3 +135,17 @@ func generateBirthdayIcs(birthdays []Birthday, today date.Date) string {
 
 	return sb.String()
 }
+
+func birthdaySummary(birthday Birthday) string {
+	if birthday.HasYear {
+		return birthday.FullName + "'s Birthday"
+	}
+	return birthday.FullName + "'s Birthday (year unknown)"
+}
+
+func birthdayDescription(birthday Birthday) string {
+	if birthday.HasYear {
+		return birthday.FullName + " born on " + birthday.Date.String()
+	}
+	return birthday.FullName + "'s birthday (year unknown)"
+}
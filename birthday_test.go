@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-vcard"
+	"github.com/rs/zerolog"
+)
+
+func TestParseBirthdayValue(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		params      vcard.Params
+		wantTime    time.Time
+		wantHasYear bool
+		wantOk      bool
+	}{
+		{
+			name:        "vcard 3.0 full date",
+			value:       "19900315",
+			wantTime:    time.Date(1990, time.March, 15, 0, 0, 0, 0, time.UTC),
+			wantHasYear: true,
+			wantOk:      true,
+		},
+		{
+			name:        "vcard 4.0 full date with dashes",
+			value:       "1990-03-15",
+			wantTime:    time.Date(1990, time.March, 15, 0, 0, 0, 0, time.UTC),
+			wantHasYear: true,
+			wantOk:      true,
+		},
+		{
+			name:        "vcard 4.0 partial date, year unknown",
+			value:       "--0315",
+			wantTime:    time.Date(noYearPlaceholder, time.March, 15, 0, 0, 0, 0, time.UTC),
+			wantHasYear: false,
+			wantOk:      true,
+		},
+		{
+			name:        "date-time value, time component is ignored",
+			value:       "19900315T120000",
+			wantTime:    time.Date(1990, time.March, 15, 0, 0, 0, 0, time.UTC),
+			wantHasYear: true,
+			wantOk:      true,
+		},
+		{
+			name:   "free-text value is unparseable",
+			value:  "circa 1990",
+			params: vcard.Params{vcard.ParamValue: []string{"text"}},
+			wantOk: false,
+		},
+		{
+			name:   "empty value",
+			value:  "",
+			wantOk: false,
+		},
+		{
+			name:   "garbage value",
+			value:  "not-a-date",
+			wantOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field := &vcard.Field{Value: tt.value, Params: tt.params}
+			gotTime, gotHasYear, gotOk := parseBirthdayValue(field)
+			if gotOk != tt.wantOk {
+				t.Fatalf("ok = %v, want %v", gotOk, tt.wantOk)
+			}
+			if !gotOk {
+				return
+			}
+			if !gotTime.Equal(tt.wantTime) {
+				t.Errorf("time = %v, want %v", gotTime, tt.wantTime)
+			}
+			if gotHasYear != tt.wantHasYear {
+				t.Errorf("hasYear = %v, want %v", gotHasYear, tt.wantHasYear)
+			}
+		})
+	}
+}
+
+func TestBirthdayFromCard(t *testing.T) {
+	t.Run("missing BDAY yields nil", func(t *testing.T) {
+		card := make(vcard.Card)
+		card.SetValue(vcard.FieldFormattedName, "Ada Lovelace")
+		if got := birthdayFromCard(card, zerolog.Nop()); got != nil {
+			t.Fatalf("got %+v, want nil", got)
+		}
+	})
+
+	t.Run("falls back to N when FN is missing", func(t *testing.T) {
+		card := make(vcard.Card)
+		card.SetValue(vcard.FieldName, "Lovelace;Ada;;;")
+		card.Set(vcard.FieldBirthday, &vcard.Field{Value: "18151210"})
+
+		got := birthdayFromCard(card, zerolog.Nop())
+		if got == nil {
+			t.Fatal("got nil, want a Birthday")
+		}
+		if got.FullName != "Ada Lovelace" {
+			t.Errorf("FullName = %q, want %q", got.FullName, "Ada Lovelace")
+		}
+		if !got.HasYear {
+			t.Error("HasYear = false, want true")
+		}
+	})
+
+	t.Run("missing name yields nil", func(t *testing.T) {
+		card := make(vcard.Card)
+		card.Set(vcard.FieldBirthday, &vcard.Field{Value: "18151210"})
+		if got := birthdayFromCard(card, zerolog.Nop()); got != nil {
+			t.Fatalf("got %+v, want nil", got)
+		}
+	})
+
+	t.Run("unparseable birthday yields nil", func(t *testing.T) {
+		card := make(vcard.Card)
+		card.SetValue(vcard.FieldFormattedName, "Ada Lovelace")
+		card.Set(vcard.FieldBirthday, &vcard.Field{Value: "not-a-date"})
+		if got := birthdayFromCard(card, zerolog.Nop()); got != nil {
+			t.Fatalf("got %+v, want nil", got)
+		}
+	})
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SimonSchneider/goslu/date"
+	"github.com/rs/zerolog"
+)
+
+// BirthdayCache holds the most recently generated ICS for each address book
+// so that repeated requests don't re-issue a CardDAV REPORT and re-render
+// the calendar every time. Staleness is checked cheaply via each discovered
+// address book's getctag before falling back to a full re-query.
+type BirthdayCache struct {
+	client *http.Client
+	ttl    time.Duration
+	logger zerolog.Logger
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	mu        sync.Mutex
+	objects   map[string]birthdayObject
+	ics       []byte
+	etag      string
+	ctags     map[string]string
+	fetchedAt time.Time
+}
+
+// birthdayObject pairs a parsed Birthday with the getetag of the vCard it
+// came from, keyed by the vCard's href, so a later refresh can tell which
+// contacts actually changed instead of re-parsing the whole address book.
+// birthday is nil when the vCard has no usable birthday, which still needs
+// tracking so an edit to that contact's etag is noticed later.
+type birthdayObject struct {
+	etag     string
+	birthday *Birthday
+}
+
+func birthdaysFromObjects(objects map[string]birthdayObject) []Birthday {
+	birthdays := make([]Birthday, 0, len(objects))
+	for _, obj := range objects {
+		if obj.birthday != nil {
+			birthdays = append(birthdays, *obj.birthday)
+		}
+	}
+	return birthdays
+}
+
+func NewBirthdayCache(client *http.Client, ttl time.Duration, logger zerolog.Logger) *BirthdayCache {
+	return &BirthdayCache{client: client, ttl: ttl, logger: logger, entries: make(map[string]*cacheEntry)}
+}
+
+func (c *BirthdayCache) entryFor(name string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[name]
+	if !ok {
+		e = &cacheEntry{}
+		c.entries[name] = e
+	}
+	return e
+}
+
+// Get returns the cached ICS body and its ETag for book, refreshing it first
+// if the cache is older than the configured TTL and the upstream ctags show
+// it's actually changed. If a refresh fails, the last good value is served.
+func (c *BirthdayCache) Get(ctx context.Context, book AddressBook) (ics []byte, etag string, err error) {
+	e := c.entryFor(book.Name)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.ics != nil && time.Since(e.fetchedAt) < c.ttl {
+		return e.ics, e.etag, nil
+	}
+
+	if e.ics != nil {
+		if unchanged, err := c.ctagsUnchanged(ctx, book, e.ctags); err == nil && unchanged {
+			e.fetchedAt = time.Now()
+			return e.ics, e.etag, nil
+		}
+	}
+
+	objects, ctags, err := c.fetchBirthdays(ctx, book, e.objects)
+	if err != nil {
+		if e.ics != nil {
+			return e.ics, e.etag, nil
+		}
+		return nil, "", err
+	}
+
+	ics = []byte(generateBirthdayIcs(birthdaysFromObjects(objects), date.Today()))
+	etag = fmt.Sprintf(`"%x"`, sha256.Sum256(ics))
+
+	e.objects, e.ics, e.etag, e.ctags, e.fetchedAt = objects, ics, etag, ctags, time.Now()
+	return e.ics, e.etag, nil
+}
+
+// Birthdays returns the cached, parsed birthdays for book, refreshing them
+// first under the same rules as Get.
+func (c *BirthdayCache) Birthdays(ctx context.Context, book AddressBook) ([]Birthday, error) {
+	if _, _, err := c.Get(ctx, book); err != nil {
+		return nil, err
+	}
+	e := c.entryFor(book.Name)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return birthdaysFromObjects(e.objects), nil
+}
+
+// RunBackgroundRefresh keeps every address book's cache warm so the first
+// request of the day doesn't pay for a cold CardDAV fetch. If ttl is
+// non-positive, background refresh is disabled and caching falls back to
+// the on-demand ctag check in Get.
+func (c *BirthdayCache) RunBackgroundRefresh(ctx context.Context, books AddressBooks) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.refreshAll(ctx, books)
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.refreshAll(ctx, books)
+		}
+	}
+}
+
+func (c *BirthdayCache) refreshAll(ctx context.Context, books AddressBooks) {
+	for _, book := range books {
+		if _, _, err := c.Get(ctx, book); err != nil {
+			c.logger.Warn().Str("addressBook", book.Name).Err(err).Msg("failed to refresh address book")
+		}
+	}
+}
+
+// fetchBirthdays resolves book's current birthdays, only re-fetching and
+// re-parsing the vCards whose getetag differs from prev; everything else is
+// carried over from prev unchanged. This keeps a single edited contact in a
+// large address book cheap to pick up instead of re-downloading and
+// re-parsing the whole collection every time its ctag moves.
+func (c *BirthdayCache) fetchBirthdays(ctx context.Context, book AddressBook, prev map[string]birthdayObject) (map[string]birthdayObject, map[string]string, error) {
+	addressBooks, err := resolveAddressBooks(ctx, c.client, book)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to discover address books: %w", err)
+	}
+
+	ctags := make(map[string]string, len(addressBooks))
+	objects := make(map[string]birthdayObject, len(prev))
+	for _, ab := range addressBooks {
+		ctag, err := fetchCtag(ctx, c.client, book, ab.url)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch ctag for %s: %w", ab.url, err)
+		}
+		ctags[ab.url] = ctag
+
+		etags, err := fetchResourceETags(ctx, c.client, book, ab.url)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch resource etags for %s: %w", ab.url, err)
+		}
+		var changed []string
+		for href, etag := range etags {
+			if prevObj, ok := prev[href]; ok && prevObj.etag == etag {
+				objects[href] = prevObj
+				continue
+			}
+			changed = append(changed, href)
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		fetched, err := getBirthdayObjects(ctx, c.client, book, ab.path, changed, c.logger)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch changed vcards for %s: %w", ab.url, err)
+		}
+		for href, obj := range fetched {
+			objects[href] = obj
+		}
+	}
+	return objects, ctags, nil
+}
+
+func (c *BirthdayCache) ctagsUnchanged(ctx context.Context, book AddressBook, prev map[string]string) (bool, error) {
+	if len(prev) == 0 {
+		return false, nil
+	}
+	urls, err := discoverAddressBookURLs(ctx, c.client, book)
+	if err != nil {
+		return false, err
+	}
+	if len(urls) != len(prev) {
+		return false, nil
+	}
+	for _, u := range urls {
+		prevCtag, ok := prev[u]
+		if !ok {
+			return false, nil
+		}
+		ctag, err := fetchCtag(ctx, c.client, book, u)
+		if err != nil {
+			return false, err
+		}
+		if ctag != prevCtag {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+const ctagPropfindBody = `<?xml version="1.0" encoding="utf-8" ?>
+<propfind xmlns="DAV:" xmlns:cs="http://calendarserver.org/ns/">
+  <prop>
+    <cs:getctag/>
+  </prop>
+</propfind>`
+
+// fetchCtag issues a depth-0 PROPFIND for the CardDAV sync hint "getctag",
+// which changes whenever any resource in the collection changes. It's far
+// cheaper than re-downloading and re-parsing every vCard just to find out
+// nothing changed.
+func fetchCtag(ctx context.Context, client *http.Client, book AddressBook, collectionURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "PROPFIND", collectionURL, strings.NewReader(ctagPropfindBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.SetBasicAuth(book.Username, book.Password)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return "", fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var multiStatus struct {
+		Responses []struct {
+			Propstat []struct {
+				Prop struct {
+					CTag string `xml:"getctag"`
+				} `xml:"prop"`
+			} `xml:"propstat"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&multiStatus); err != nil {
+		return "", fmt.Errorf("failed to parse XML response: %w", err)
+	}
+	for _, response := range multiStatus.Responses {
+		for _, propstat := range response.Propstat {
+			if propstat.Prop.CTag != "" {
+				return propstat.Prop.CTag, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+const etagPropfindBody = `<?xml version="1.0" encoding="utf-8" ?>
+<propfind xmlns="DAV:">
+  <prop>
+    <getetag/>
+  </prop>
+</propfind>`
+
+// fetchResourceETags issues a depth-1 PROPFIND for the getetag of every
+// resource directly inside collectionURL, without requesting any address
+// data. It's used to tell which individual vCards actually changed since the
+// last fetch, so only those need to be re-downloaded and re-parsed.
+func fetchResourceETags(ctx context.Context, client *http.Client, book AddressBook, collectionURL string) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, "PROPFIND", collectionURL, strings.NewReader(etagPropfindBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.SetBasicAuth(book.Username, book.Password)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "1")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var multiStatus struct {
+		Responses []struct {
+			Href     string `xml:"href"`
+			Propstat []struct {
+				Prop struct {
+					ETag string `xml:"getetag"`
+				} `xml:"prop"`
+			} `xml:"propstat"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&multiStatus); err != nil {
+		return nil, fmt.Errorf("failed to parse XML response: %w", err)
+	}
+	etags := make(map[string]string, len(multiStatus.Responses))
+	for _, response := range multiStatus.Responses {
+		href, err := url.Parse(response.Href)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse href %s: %w", response.Href, err)
+		}
+		if strings.HasSuffix(href.Path, "/") {
+			// Depth:1 also reports the collection itself, which is always a
+			// directory-like href; it has no vCard etag worth tracking here
+			// (getctag already covers it).
+			continue
+		}
+		for _, propstat := range response.Propstat {
+			if propstat.Prop.ETag != "" {
+				etags[href.Path] = propstat.Prop.ETag
+			}
+		}
+	}
+	return etags, nil
+}
+
+// resolvedAddressBook pairs a discovered address book's relative path (as
+// used by the carddav.Client REPORT methods) with its absolute URL (as used
+// by the plain HTTP requests fetchCtag and fetchResourceETags make).
+type resolvedAddressBook struct {
+	path string
+	url  string
+}
+
+// resolveAddressBooks discovers book's address book collections and resolves
+// each one's path into an absolute URL.
+func resolveAddressBooks(ctx context.Context, client *http.Client, book AddressBook) ([]resolvedAddressBook, error) {
+	davClient, err := newCardDAVClient(client, book)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create carddav client: %w", err)
+	}
+	addressBooks, err := discoverAddressBooks(ctx, davClient, book)
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(book.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse address book URL: %w", err)
+	}
+	resolved := make([]resolvedAddressBook, 0, len(addressBooks))
+	for _, ab := range addressBooks {
+		ref, err := url.Parse(ab.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse discovered address book path %s: %w", ab.Path, err)
+		}
+		resolved = append(resolved, resolvedAddressBook{path: ab.Path, url: base.ResolveReference(ref).String()})
+	}
+	return resolved, nil
+}
+
+// discoverAddressBookURLs resolves the address book collections for book
+// into absolute URLs, for use with plain HTTP requests like fetchCtag.
+func discoverAddressBookURLs(ctx context.Context, client *http.Client, book AddressBook) ([]string, error) {
+	resolved, err := resolveAddressBooks(ctx, client, book)
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0, len(resolved))
+	for _, ab := range resolved {
+		urls = append(urls, ab.url)
+	}
+	return urls, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchCtag(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" || r.Header.Get("Depth") != "0" {
+			t.Errorf("unexpected request: %s Depth=%s", r.Method, r.Header.Get("Depth"))
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(`<?xml version="1.0"?>
+<multistatus xmlns="DAV:" xmlns:cs="http://calendarserver.org/ns/">
+  <response>
+    <href>/addressbooks/alice/default/</href>
+    <propstat>
+      <prop><cs:getctag>ctag-1</cs:getctag></prop>
+      <status>HTTP/1.1 200 OK</status>
+    </propstat>
+  </response>
+</multistatus>`))
+	}))
+	defer srv.Close()
+
+	book := AddressBook{Username: "alice", Password: "secret"}
+	ctag, err := fetchCtag(context.Background(), srv.Client(), book, srv.URL)
+	if err != nil {
+		t.Fatalf("fetchCtag: %v", err)
+	}
+	if ctag != "ctag-1" {
+		t.Errorf("ctag = %q, want %q", ctag, "ctag-1")
+	}
+}
+
+func TestFetchResourceETags(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" || r.Header.Get("Depth") != "1" {
+			t.Errorf("unexpected request: %s Depth=%s", r.Method, r.Header.Get("Depth"))
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(`<?xml version="1.0"?>
+<multistatus xmlns="DAV:">
+  <response>
+    <href>/addressbooks/alice/default/</href>
+    <propstat>
+      <prop><getctag>ctag-1</getctag></prop>
+      <status>HTTP/1.1 200 OK</status>
+    </propstat>
+  </response>
+  <response>
+    <href>/addressbooks/alice/default/ada.vcf</href>
+    <propstat>
+      <prop><getetag>"etag-1"</getetag></prop>
+      <status>HTTP/1.1 200 OK</status>
+    </propstat>
+  </response>
+  <response>
+    <href>/addressbooks/alice/default/bob.vcf</href>
+    <propstat>
+      <prop><getetag>"etag-2"</getetag></prop>
+      <status>HTTP/1.1 200 OK</status>
+    </propstat>
+  </response>
+</multistatus>`))
+	}))
+	defer srv.Close()
+
+	book := AddressBook{Username: "alice", Password: "secret"}
+	etags, err := fetchResourceETags(context.Background(), srv.Client(), book, srv.URL+"/addressbooks/alice/default/")
+	if err != nil {
+		t.Fatalf("fetchResourceETags: %v", err)
+	}
+
+	want := map[string]string{
+		"/addressbooks/alice/default/ada.vcf": `"etag-1"`,
+		"/addressbooks/alice/default/bob.vcf": `"etag-2"`,
+	}
+	if len(etags) != len(want) {
+		t.Fatalf("etags = %v, want %v", etags, want)
+	}
+	for href, etag := range want {
+		if etags[href] != etag {
+			t.Errorf("etags[%q] = %q, want %q", href, etags[href], etag)
+		}
+	}
+	if _, ok := etags["/addressbooks/alice/default/"]; ok {
+		t.Error("collection's own href should not appear in resource etags")
+	}
+}
+
+func TestBirthdaysFromObjects(t *testing.T) {
+	objects := map[string]birthdayObject{
+		"/a.vcf": {etag: "e1", birthday: &Birthday{FullName: "Ada"}},
+		"/b.vcf": {etag: "e2", birthday: nil},
+	}
+	got := birthdaysFromObjects(objects)
+	if len(got) != 1 {
+		t.Fatalf("got %d birthdays, want 1 (nil birthdays should be skipped)", len(got))
+	}
+	if got[0].FullName != "Ada" {
+		t.Errorf("FullName = %q, want %q", got[0].FullName, "Ada")
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/caldav"
+)
+
+// DavHandler serves the synthesized birthday calendars over CalDAV at
+// /dav/{addressBook}/..., so calendar clients can discover and subscribe
+// to them directly instead of polling the ICS endpoint. Mirrors Handler's
+// apiKey check, since CalDAV clients happily keep a query string in the
+// collection URL they're given.
+//
+// The request's path is passed through to caldav.Handler unmodified, with
+// Prefix set to the /dav/{addressBook} segment: the library classifies a
+// request purely by counting path segments after stripping Prefix, then
+// compares that same unstripped path against whatever birthdayCalDAVBackend
+// returns, so Prefix and the backend's paths must agree on the same,
+// un-rewritten path space.
+func DavHandler(addressBooks AddressBooks, cache *BirthdayCache, apiKey string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("apiKey") != apiKey {
+			http.Error(w, "invalid api key", http.StatusUnauthorized)
+			return
+		}
+
+		rest := strings.TrimPrefix(r.URL.Path, "/dav/")
+		name, _, _ := strings.Cut(rest, "/")
+		book, ok := addressBooks[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("address book %s not found", name), http.StatusNotFound)
+			return
+		}
+
+		backend := &birthdayCalDAVBackend{book: book, cache: cache}
+		(&caldav.Handler{Backend: backend, Prefix: "/dav/" + name}).ServeHTTP(w, r)
+	})
+}
+
+// birthdayCalDAVBackend exposes a single address book's birthdays as a
+// read-only CalDAV calendar collection. It reads through the shared
+// BirthdayCache rather than querying the upstream CardDAV server directly,
+// since CalDAV clients poll a subscribed collection far more often than the
+// plain ICS endpoint is ever fetched.
+//
+// Its paths follow caldav.Handler's expected one-level-per-resourceType
+// layout (principal, then calendar home set, then calendar, then calendar
+// object), each nested directly under the previous one, since the library
+// classifies a PROPFIND by path depth alone.
+type birthdayCalDAVBackend struct {
+	book  AddressBook
+	cache *BirthdayCache
+}
+
+func (b *birthdayCalDAVBackend) principalPath() string {
+	return "/dav/" + b.book.Name + "/principal/"
+}
+
+func (b *birthdayCalDAVBackend) homeSetPath() string {
+	return b.principalPath() + "calendars/"
+}
+
+func (b *birthdayCalDAVBackend) calendarPath() string {
+	return b.homeSetPath() + "default/"
+}
+
+func (b *birthdayCalDAVBackend) CurrentUserPrincipal(ctx context.Context) (string, error) {
+	return b.principalPath(), nil
+}
+
+func (b *birthdayCalDAVBackend) CalendarHomeSetPath(ctx context.Context) (string, error) {
+	return b.homeSetPath(), nil
+}
+
+func (b *birthdayCalDAVBackend) ListCalendars(ctx context.Context) ([]caldav.Calendar, error) {
+	return []caldav.Calendar{{
+		Path:                  b.calendarPath(),
+		Name:                  b.book.Name + " birthdays",
+		Description:           "Birthdays synced from the " + b.book.Name + " address book",
+		SupportedComponentSet: []string{"VEVENT"},
+	}}, nil
+}
+
+func (b *birthdayCalDAVBackend) GetCalendar(ctx context.Context, path string) (*caldav.Calendar, error) {
+	calendars, err := b.ListCalendars(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, calendar := range calendars {
+		if calendar.Path == path {
+			return &calendar, nil
+		}
+	}
+	return nil, webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("calendar %s not found", path))
+}
+
+// CreateCalendar is not supported: the birthday calendar is synthesized
+// read-only from the address book's CardDAV data.
+func (b *birthdayCalDAVBackend) CreateCalendar(ctx context.Context, calendar *caldav.Calendar) error {
+	return webdav.NewHTTPError(http.StatusForbidden, fmt.Errorf("creating calendars is not supported"))
+}
+
+func (b *birthdayCalDAVBackend) ListCalendarObjects(ctx context.Context, path string, req *caldav.CalendarCompRequest) ([]caldav.CalendarObject, error) {
+	return b.objects(ctx)
+}
+
+// PutCalendarObject is not supported: birthdays are derived from the address
+// book, not written directly through the CalDAV collection.
+func (b *birthdayCalDAVBackend) PutCalendarObject(ctx context.Context, path string, calendar *ical.Calendar, opts *caldav.PutCalendarObjectOptions) (*caldav.CalendarObject, error) {
+	return nil, webdav.NewHTTPError(http.StatusForbidden, fmt.Errorf("writing calendar objects is not supported"))
+}
+
+// DeleteCalendarObject is not supported, for the same reason as PutCalendarObject.
+func (b *birthdayCalDAVBackend) DeleteCalendarObject(ctx context.Context, path string) error {
+	return webdav.NewHTTPError(http.StatusForbidden, fmt.Errorf("deleting calendar objects is not supported"))
+}
+
+func (b *birthdayCalDAVBackend) GetCalendarObject(ctx context.Context, path string, req *caldav.CalendarCompRequest) (*caldav.CalendarObject, error) {
+	objects, err := b.objects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		if object.Path == path {
+			return &object, nil
+		}
+	}
+	return nil, webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("calendar object %s not found", path))
+}
+
+func (b *birthdayCalDAVBackend) QueryCalendarObjects(ctx context.Context, path string, query *caldav.CalendarQuery) ([]caldav.CalendarObject, error) {
+	return b.objects(ctx)
+}
+
+func (b *birthdayCalDAVBackend) objects(ctx context.Context) ([]caldav.CalendarObject, error) {
+	birthdays, err := b.cache.Birthdays(ctx, b.book)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get birthdays: %w", err)
+	}
+	objects := make([]caldav.CalendarObject, 0, len(birthdays))
+	for _, birthday := range birthdays {
+		objects = append(objects, birthdayCalendarObject(birthday, b.calendarPath()))
+	}
+	return objects, nil
+}
+
+func birthdayCalendarObject(birthday Birthday, calendarPath string) caldav.CalendarObject {
+	event := ical.NewEvent()
+	event.Props.SetText(ical.PropUID, fmt.Sprintf("%s-birthday", birthday.UID))
+	event.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
+	event.Props.Set(&ical.Prop{
+		Name:   ical.PropDateTimeStart,
+		Params: ical.Params{"VALUE": {"DATE"}},
+		Value:  birthday.Date.ToStdTime().Format("20060102"),
+	})
+	event.Props.SetText("RRULE", "FREQ=YEARLY")
+	event.Props.SetText(ical.PropSummary, birthdaySummary(birthday))
+	event.Props.SetText(ical.PropDescription, birthdayDescription(birthday))
+
+	cal := ical.NewCalendar()
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	cal.Props.SetText(ical.PropProductID, "-//CardDAV Birthdays//EN")
+	cal.Children = append(cal.Children, event.Component)
+
+	path := fmt.Sprintf("%s%s.ics", calendarPath, birthday.UID)
+	etagInput := fmt.Sprintf("%s%s%s%t", path, birthday.Date.String(), birthday.FullName, birthday.HasYear)
+	return caldav.CalendarObject{
+		Path: path,
+		ETag: fmt.Sprintf("%x", sha256.Sum256([]byte(etagInput))),
+		Data: cal,
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SimonSchneider/goslu/date"
+	"github.com/rs/zerolog"
+)
+
+// cachedBirthdayCache returns a BirthdayCache whose "alice" entry is already
+// warm, so DavHandler can be exercised over real HTTP without an upstream
+// CardDAV server.
+func cachedBirthdayCache(t *testing.T, birthdays ...Birthday) *BirthdayCache {
+	t.Helper()
+	objects := make(map[string]birthdayObject, len(birthdays))
+	for i, b := range birthdays {
+		b := b
+		objects[strings.Repeat("x", i+1)+".vcf"] = birthdayObject{etag: "etag", birthday: &b}
+	}
+	cache := NewBirthdayCache(http.DefaultClient, time.Hour, zerolog.Nop())
+	cache.entries["alice"] = &cacheEntry{
+		objects:   objects,
+		ics:       []byte(""),
+		etag:      `"etag"`,
+		ctags:     map[string]string{},
+		fetchedAt: time.Now(),
+	}
+	return cache
+}
+
+func TestDavHandlerCalendarDiscovery(t *testing.T) {
+	addressBooks := NewAddressBooks(AddressBook{Name: "alice"})
+	cache := cachedBirthdayCache(t, Birthday{UID: "u1", FullName: "Ada Lovelace", Date: date.Today(), HasYear: true})
+	handler := DavHandler(addressBooks, cache, "testkey")
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	client := srv.Client()
+
+	propfind := func(path string, depth string) *http.Response {
+		req, err := http.NewRequest("PROPFIND", srv.URL+path+"?apiKey=testkey", strings.NewReader(`<?xml version="1.0"?>
+<propfind xmlns="DAV:"><allprop/></propfind>`))
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		req.Header.Set("Depth", depth)
+		req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("PROPFIND %s: %v", path, err)
+		}
+		return resp
+	}
+
+	// Discovery chain: principal -> calendar home set -> calendar -> objects,
+	// each one level down from the last, mirroring how a real CalDAV client
+	// (iOS/macOS/Thunderbird) walks the hierarchy.
+	resp := propfind("/dav/alice/principal/", "1")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND principal: status = %d, body = %s", resp.StatusCode, body)
+	}
+	if !strings.Contains(string(body), "/dav/alice/principal/calendars/") {
+		t.Errorf("principal PROPFIND response missing calendar-home-set href, got: %s", body)
+	}
+
+	resp = propfind("/dav/alice/principal/calendars/", "1")
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND home set: status = %d, body = %s", resp.StatusCode, body)
+	}
+	if !strings.Contains(string(body), "/dav/alice/principal/calendars/default/") {
+		t.Errorf("home-set PROPFIND response missing calendar href, got: %s", body)
+	}
+
+	resp = propfind("/dav/alice/principal/calendars/default/", "1")
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND calendar: status = %d, body = %s", resp.StatusCode, body)
+	}
+	if !strings.Contains(string(body), "u1.ics") {
+		t.Errorf("calendar PROPFIND response missing birthday object, got: %s", body)
+	}
+
+	resp, err := client.Get(srv.URL + "/dav/alice/principal/calendars/default/u1.ics?apiKey=testkey")
+	if err != nil {
+		t.Fatalf("GET calendar object: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET calendar object: status = %d, body = %s", resp.StatusCode, body)
+	}
+	if !strings.Contains(string(body), "Ada Lovelace") {
+		t.Errorf("calendar object body missing birthday, got: %s", body)
+	}
+}
+
+// TestBirthdayCalendarObjectUIDLess covers a Birthday whose UID is a hashed
+// href fallback rather than a real vCard UID (see getBirthdayObjects): the
+// hash must not contain slashes, or it would nest extra path segments into
+// the object's path and break the one-level-per-resourceType invariant that
+// caldav.Handler relies on to classify requests.
+func TestBirthdayCalendarObjectUIDLess(t *testing.T) {
+	birthday := Birthday{UID: "deadbeef", FullName: "Ada Lovelace", Date: date.Today(), HasYear: true}
+	object := birthdayCalendarObject(birthday, "/dav/alice/principal/calendars/default/")
+
+	wantPath := "/dav/alice/principal/calendars/default/deadbeef.ics"
+	if object.Path != wantPath {
+		t.Errorf("Path = %q, want %q", object.Path, wantPath)
+	}
+	if strings.Count(object.Path, "/") != strings.Count(wantPath, "/") {
+		t.Errorf("Path = %q has unexpected extra segments", object.Path)
+	}
+}
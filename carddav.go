@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+
+	"github.com/emersion/go-vcard"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/carddav"
+	"github.com/rs/zerolog"
+)
+
+// birthdayDataRequest selects the vCard properties birthdayFromCard needs,
+// so neither a full query nor a multi-get pulls down more of a contact than
+// this app actually uses.
+var birthdayDataRequest = carddav.AddressDataRequest{
+	Props: []string{vcard.FieldName, vcard.FieldFormattedName, vcard.FieldBirthday, vcard.FieldUID},
+}
+
+// getBirthdayObjects fetches and parses only the vCards at hrefs, the
+// address book at abPath's resources whose getetag changed since the last
+// fetch, keyed by href so the caller can merge them into its cached set
+// instead of discarding everything else in the collection.
+func getBirthdayObjects(ctx context.Context, client *http.Client, book AddressBook, abPath string, hrefs []string, logger zerolog.Logger) (map[string]birthdayObject, error) {
+	davClient, err := newCardDAVClient(client, book)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create carddav client: %w", err)
+	}
+
+	objects, err := davClient.MultiGetAddressBook(ctx, abPath, &carddav.AddressBookMultiGet{
+		Paths:       hrefs,
+		DataRequest: birthdayDataRequest,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to multi-get address book %s: %w", abPath, err)
+	}
+
+	result := make(map[string]birthdayObject, len(objects))
+	for _, obj := range objects {
+		birthday := birthdayFromCard(obj.Card, logger)
+		if birthday != nil && birthday.UID == "" {
+			// Not every vCard carries a UID; fall back to a hash of the href,
+			// which is already unique within the collection, so two UID-less
+			// contacts don't collide on the same CalDAV object path. The href
+			// is hashed rather than used verbatim since it contains slashes
+			// that would otherwise nest extra path segments into the CalDAV
+			// object's path.
+			birthday.UID = fmt.Sprintf("%x", sha256.Sum256([]byte(obj.Path)))
+		}
+		result[obj.Path] = birthdayObject{etag: obj.ETag, birthday: birthday}
+	}
+	return result, nil
+}
+
+func newCardDAVClient(client *http.Client, book AddressBook) (*carddav.Client, error) {
+	httpClient := webdav.HTTPClientWithBasicAuth(client, book.Username, book.Password)
+	return carddav.NewClient(httpClient, book.URL)
+}
+
+// discoverAddressBooks resolves the address book collections to query for
+// book. If book.Principal is set, book.URL is treated as the server's base
+// URL and FindAddressBookHomeSet/FindAddressBooks are used to discover every
+// collection under it, so a single config entry can expand into several
+// address books. Otherwise book.URL is queried directly, as before.
+func discoverAddressBooks(ctx context.Context, client *carddav.Client, book AddressBook) ([]carddav.AddressBook, error) {
+	if book.Principal == "" {
+		return []carddav.AddressBook{{Path: book.URL}}, nil
+	}
+
+	homeSet, err := client.FindAddressBookHomeSet(ctx, book.Principal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find address book home set: %w", err)
+	}
+
+	addressBooks, err := client.FindAddressBooks(ctx, homeSet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find address books: %w", err)
+	}
+	return addressBooks, nil
+}
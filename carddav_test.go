@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// TestGetBirthdayObjectsUIDLessFallback covers a vCard with no UID: the
+// fallback must be a hash of the href, not the href itself, since the href
+// contains slashes that would otherwise nest extra segments into the
+// synthesized CalDAV object's path (see birthdayCalendarObject).
+func TestGetBirthdayObjectsUIDLessFallback(t *testing.T) {
+	const href = "/addressbooks/alice/default/ada.vcf"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<multistatus xmlns="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">
+  <response>
+    <href>%s</href>
+    <propstat>
+      <prop><card:address-data>BEGIN:VCARD
+VERSION:3.0
+FN:Ada Lovelace
+BDAY:1985-12-10
+END:VCARD
+</card:address-data></prop>
+      <status>HTTP/1.1 200 OK</status>
+    </propstat>
+  </response>
+</multistatus>`, href)
+	}))
+	defer srv.Close()
+
+	book := AddressBook{Username: "alice", Password: "secret", URL: srv.URL}
+	objects, err := getBirthdayObjects(context.Background(), srv.Client(), book, srv.URL, []string{href}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("getBirthdayObjects: %v", err)
+	}
+
+	obj, ok := objects[href]
+	if !ok || obj.birthday == nil {
+		t.Fatalf("objects[%q] = %+v, want a parsed birthday", href, objects[href])
+	}
+
+	wantUID := fmt.Sprintf("%x", sha256.Sum256([]byte(href)))
+	if obj.birthday.UID != wantUID {
+		t.Errorf("UID = %q, want hashed href %q", obj.birthday.UID, wantUID)
+	}
+	if strings.Contains(obj.birthday.UID, "/") {
+		t.Errorf("UID %q must not contain slashes, or it nests extra segments into the CalDAV object path", obj.birthday.UID)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// LogConfig controls the zerolog output produced by the server, exposed as
+// the -log-json and -log-level flags.
+type LogConfig struct {
+	JSON  bool
+	Level string
+}
+
+func newLogger(cfg LogConfig, out io.Writer) zerolog.Logger {
+	level, err := zerolog.ParseLevel(cfg.Level)
+	if err != nil {
+		level = zerolog.InfoLevel
+	}
+	var writer io.Writer = out
+	if !cfg.JSON {
+		writer = zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339}
+	}
+	return zerolog.New(writer).Level(level).With().Timestamp().Logger()
+}
+
+// redactPrincipal returns an identifier safe to put in logs: enough to
+// correlate requests from the same principal without leaking it wholesale.
+func redactPrincipal(principal string) string {
+	if principal == "" {
+		return ""
+	}
+	if len(principal) <= 2 {
+		return "***"
+	}
+	return principal[:1] + "***" + principal[len(principal)-1:]
+}
+
+// statusResponseWriter records the status code and byte count written
+// through it, for access logging.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"encoding/xml"
 	"flag"
 	"fmt"
 	"io"
@@ -12,11 +11,11 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
+	"time"
 
 	"github.com/SimonSchneider/goslu/config"
-	"github.com/SimonSchneider/goslu/date"
 	"github.com/SimonSchneider/goslu/srvu"
+	"github.com/rs/zerolog"
 )
 
 func main() {
@@ -35,15 +34,32 @@ func Run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer,
 	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, os.Kill)
 	defer cancel()
 	logger := srvu.LogToOutput(log.New(stdout, "", log.LstdFlags|log.Lshortfile))
+	zlog := newLogger(cfg.Log, stdout)
 
 	addressBooks, err := readAddressBooks(cfg.AddressBooksFile)
 	if err != nil {
 		return fmt.Errorf("failed to read address books: %w", err)
 	}
+	notifiers, err := readNotifiers(cfg.NotifiersFile)
+	if err != nil {
+		return fmt.Errorf("failed to read notifiers: %w", err)
+	}
+
+	cache := NewBirthdayCache(client, cfg.CacheTTL, zlog)
+	go cache.RunBackgroundRefresh(ctx, addressBooks)
+
+	scheduler := NewReminderScheduler(cache, notifiers, zlog)
+	cronRunner, err := scheduler.Start(ctx, addressBooks)
+	if err != nil {
+		return fmt.Errorf("failed to start reminder scheduler: %w", err)
+	}
+	defer cronRunner.Stop()
 
 	mux := http.NewServeMux()
 
-	mux.Handle("/{addressBook}", Handler(addressBooks, client, cfg.ApiKey))
+	mux.Handle("/{addressBook}", Handler(addressBooks, cache, cfg.ApiKey, zlog))
+	mux.Handle("/dav/", DavHandler(addressBooks, cache, cfg.ApiKey))
+	mux.Handle("/preview-notifications", PreviewNotificationsHandler(addressBooks, cache, cfg.ApiKey))
 
 	srv := &http.Server{
 		BaseContext: func(listener net.Listener) context.Context {
@@ -59,10 +75,14 @@ func Run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer,
 type Config struct {
 	Addr             string
 	AddressBooksFile string
+	NotifiersFile    string
 	ApiKey           string
+	CacheTTL         time.Duration
+	Log              LogConfig
 }
 
 func parseConfig(args []string, getEnv func(string) string) (cfg Config, err error) {
+	cfg.CacheTTL = time.Hour
 	err = config.ParseInto(&cfg, flag.NewFlagSet("", flag.ExitOnError), args, getEnv)
 	return cfg, err
 }
@@ -81,26 +101,52 @@ func readAddressBooks(file string) (AddressBooks, error) {
 	return NewAddressBooks(addressBooks...), nil
 }
 
-func Handler(addressBooks AddressBooks, client *http.Client, apiKey string) http.Handler {
+func Handler(addressBooks AddressBooks, cache *BirthdayCache, apiKey string, logger zerolog.Logger) http.Handler {
 	return srvu.ErrHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		start := time.Now()
+		sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		name := r.PathValue("addressBook")
+		var principal string
+		defer func() {
+			logger.Info().
+				Str("addressBook", name).
+				Str("principal", redactPrincipal(principal)).
+				Int("status", sw.status).
+				Int("bytes", sw.bytes).
+				Dur("duration", time.Since(start)).
+				Msg("request")
+		}()
+
 		key := r.FormValue("apiKey")
 		if key != apiKey {
-			return fmt.Errorf("invalid api key")
+			sw.status = http.StatusUnauthorized
+			return srvu.Err(sw.status, fmt.Errorf("invalid api key"))
 		}
-		name := r.PathValue("addressBook")
 		book, ok := addressBooks[name]
 		if !ok {
-			return fmt.Errorf("address book %s not found", name)
+			sw.status = http.StatusNotFound
+			return srvu.Err(sw.status, fmt.Errorf("address book %s not found", name))
 		}
-		calendar, err := getBirtdaysAndGenerateIcs(ctx, client, book)
+		if principal = book.Principal; principal == "" {
+			principal = book.Username
+		}
+
+		calendar, etag, err := cache.Get(ctx, book)
 		if err != nil {
-			return fmt.Errorf("failed to get birthdays: %w", err)
+			sw.status = http.StatusInternalServerError
+			return srvu.Err(sw.status, fmt.Errorf("failed to get birthdays: %w", err))
 		}
 
-		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
-		w.Header().Set("Content-Disposition", fmt.Sprintf("filename=%s-birthdays.ics", name))
+		sw.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			sw.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+
+		sw.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		sw.Header().Set("Content-Disposition", fmt.Sprintf("filename=%s-birthdays.ics", name))
 
-		_, err = w.Write([]byte(calendar))
+		_, err = sw.Write(calendar)
 		return err
 	})
 }
@@ -120,83 +166,14 @@ type AddressBook struct {
 	URL      string
 	Username string
 	Password string
-}
-
-func getBirtdaysAndGenerateIcs(ctx context.Context, client *http.Client, book AddressBook) (string, error) {
-	birthdays, err := getBirthdays(ctx, client, book)
-	if err != nil {
-		return "", fmt.Errorf("failed to get birthdays: %w", err)
-	}
-	return generateBirthdayIcs(birthdays, date.Today()), nil
-}
-
-func getBirthdays(ctx context.Context, client *http.Client, book AddressBook) ([]Birthday, error) {
-	req, err := http.NewRequestWithContext(ctx, "REPORT", book.URL, strings.NewReader(birthdayRequestBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.SetBasicAuth(book.Username, book.Password)
-	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
-	req.Header.Set("Depth", "1")
-
-	// Make the request
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusMultiStatus {
-		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
-	}
-
-	var propfindResponse MultiStatus
-	if err := xml.NewDecoder(resp.Body).Decode(&propfindResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse XML response: %w", err)
-	}
-
-	var birthdays []Birthday
-
-	for _, response := range propfindResponse.Responses {
-		for _, propstat := range response.Propstat {
-			vcard := propstat.Prop.AddressData
-			birthday := parseBirthdayVCard(vcard)
-			if birthday != nil {
-				birthdays = append(birthdays, *birthday)
-			}
-		}
-	}
-	return birthdays, nil
-}
-
-const birthdayRequestBody = `<?xml version="1.0" encoding="utf-8" ?>
-<card:addressbook-query xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">
-  <d:prop>
-    <d:getetag/>
-    <card:address-data>
-      <card:prop name="N"/>
-      <card:prop name="FN"/>
-      <card:prop name="BDAY"/>
-    </card:address-data>
-  </d:prop>
-</card:addressbook-query>`
-
-// DAV multistatus response
-type MultiStatus struct {
-	XMLName   xml.Name   `xml:"multistatus"`
-	Responses []Response `xml:"response"`
-}
-
-type Response struct {
-	Href     string     `xml:"href"`
-	Propstat []Propstat `xml:"propstat"`
-}
-
-type Propstat struct {
-	Prop Prop `xml:"prop"`
-}
-
-type Prop struct {
-	AddressData string `xml:"address-data"`
+	// Principal, if set, treats URL as the CardDAV server's base URL and
+	// discovers every address book collection under it instead of querying
+	// URL as a single fixed collection.
+	Principal string
+	// Cron is the schedule, in standard 5-field cron syntax, on which this
+	// address book's Notifications are checked and dispatched.
+	Cron string
+	// Notifications lists the notifiers to dispatch due birthdays to and
+	// which days-until offsets should trigger them.
+	Notifications []NotificationRef
 }
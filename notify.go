@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SimonSchneider/goslu/date"
+	"github.com/SimonSchneider/goslu/srvu"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+)
+
+// Notification describes a single upcoming birthday reminder.
+type Notification struct {
+	Name      string
+	Date      date.Date
+	Age       int
+	HasAge    bool
+	DaysUntil int
+}
+
+// Notifier dispatches a Notification through some external channel.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// NotifierConfig is the on-disk description of a named Notifier, referenced
+// by AddressBook.Notifications.
+type NotifierConfig struct {
+	Name    string
+	Type    string
+	SMTP    *SMTPNotifier    `json:",omitempty"`
+	Webhook *WebhookNotifier `json:",omitempty"`
+	Ntfy    *NtfyNotifier    `json:",omitempty"`
+}
+
+func newNotifier(cfg NotifierConfig) (Notifier, error) {
+	switch cfg.Type {
+	case "smtp":
+		if cfg.SMTP == nil {
+			return nil, fmt.Errorf("notifier %s: missing smtp config", cfg.Name)
+		}
+		return cfg.SMTP, nil
+	case "webhook":
+		if cfg.Webhook == nil {
+			return nil, fmt.Errorf("notifier %s: missing webhook config", cfg.Name)
+		}
+		return cfg.Webhook, nil
+	case "ntfy":
+		if cfg.Ntfy == nil {
+			return nil, fmt.Errorf("notifier %s: missing ntfy config", cfg.Name)
+		}
+		return cfg.Ntfy, nil
+	default:
+		return nil, fmt.Errorf("notifier %s: unknown type %q", cfg.Name, cfg.Type)
+	}
+}
+
+func readNotifiers(file string) (map[string]Notifier, error) {
+	if file == "" {
+		return nil, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open notifiers file: %w", err)
+	}
+	defer f.Close()
+
+	var configs []NotifierConfig
+	if err := json.NewDecoder(f).Decode(&configs); err != nil {
+		return nil, fmt.Errorf("failed to decode notifiers: %w", err)
+	}
+
+	notifiers := make(map[string]Notifier, len(configs))
+	for _, cfg := range configs {
+		notifier, err := newNotifier(cfg)
+		if err != nil {
+			return nil, err
+		}
+		notifiers[cfg.Name] = notifier
+	}
+	return notifiers, nil
+}
+
+// SMTPNotifier emails a plain-text reminder for each due birthday.
+type SMTPNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s *SMTPNotifier) Notify(ctx context.Context, n Notification) error {
+	addr := fmt.Sprintf("%s:%d", s.Host, s.Port)
+	auth := smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	subject := fmt.Sprintf("Upcoming birthday: %s", sanitizeHeaderValue(n.Name))
+	msg := fmt.Sprintf("To: %s\r\nFrom: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		joinAddrs(s.To), s.From, subject, notificationBody(n))
+	return smtp.SendMail(addr, auth, s.From, s.To, []byte(msg))
+}
+
+// sanitizeHeaderValue strips CR and LF from a value before it's interpolated
+// into an RFC 5322 header, so a contact's name from the upstream address
+// book can't inject extra headers or terminate the header block early.
+func sanitizeHeaderValue(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
+
+func joinAddrs(addrs []string) string {
+	var buf bytes.Buffer
+	for i, a := range addrs {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(a)
+	}
+	return buf.String()
+}
+
+// WebhookNotifier POSTs a JSON payload describing the due birthday.
+type WebhookNotifier struct {
+	URL     string
+	Headers map[string]string
+}
+
+type webhookPayload struct {
+	Name      string `json:"name"`
+	Date      string `json:"date"`
+	Age       *int   `json:"age,omitempty"`
+	DaysUntil int    `json:"days_until"`
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	payload := webhookPayload{Name: n.Name, Date: n.Date.String(), DaysUntil: n.DaysUntil}
+	if n.HasAge {
+		payload.Age = &n.Age
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// NtfyNotifier publishes a reminder to an ntfy.sh (or self-hosted) topic.
+type NtfyNotifier struct {
+	Server string
+	Topic  string
+}
+
+func (n *NtfyNotifier) Notify(ctx context.Context, notification Notification) error {
+	server := n.Server
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+	topicURL, err := url.JoinPath(server, n.Topic)
+	if err != nil {
+		return fmt.Errorf("failed to build ntfy URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, topicURL, bytes.NewReader([]byte(notificationBody(notification))))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Title", fmt.Sprintf("Upcoming birthday: %s", notification.Name))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+func notificationBody(n Notification) string {
+	switch {
+	case n.DaysUntil == 0:
+		return n.Name + "'s birthday is today" + ageSuffix(n)
+	case n.DaysUntil == 1:
+		return n.Name + "'s birthday is tomorrow" + ageSuffix(n)
+	default:
+		return fmt.Sprintf("%s's birthday is in %d days%s", n.Name, n.DaysUntil, ageSuffix(n))
+	}
+}
+
+func ageSuffix(n Notification) string {
+	if !n.HasAge {
+		return ""
+	}
+	return " (turning " + strconv.Itoa(n.Age) + ")"
+}
+
+// NotificationRef ties a configured Notifier to the days-until offsets it
+// should fire on, e.g. Offsets: [0, 1, 7] for "today, tomorrow, and in a week".
+type NotificationRef struct {
+	Notifier string
+	Offsets  []int
+}
+
+// ReminderScheduler runs each address book's reminder check on its own cron
+// schedule, dispatching due birthdays to its configured notifiers.
+type ReminderScheduler struct {
+	cache     *BirthdayCache
+	notifiers map[string]Notifier
+	logger    zerolog.Logger
+}
+
+func NewReminderScheduler(cache *BirthdayCache, notifiers map[string]Notifier, logger zerolog.Logger) *ReminderScheduler {
+	return &ReminderScheduler{cache: cache, notifiers: notifiers, logger: logger}
+}
+
+// Start schedules a cron job per address book that has both a Cron
+// expression and Notifications configured, and returns the running
+// scheduler so the caller can Stop it on shutdown.
+func (s *ReminderScheduler) Start(ctx context.Context, books AddressBooks) (*cron.Cron, error) {
+	c := cron.New()
+	for _, book := range books {
+		if book.Cron == "" || len(book.Notifications) == 0 {
+			continue
+		}
+		book := book
+		if _, err := c.AddFunc(book.Cron, func() {
+			if err := s.run(ctx, book); err != nil {
+				s.logger.Warn().Str("addressBook", book.Name).Err(err).Msg("failed to send birthday notifications")
+			}
+		}); err != nil {
+			return nil, fmt.Errorf("address book %s: invalid cron expression %q: %w", book.Name, book.Cron, err)
+		}
+	}
+	c.Start()
+	return c, nil
+}
+
+func (s *ReminderScheduler) run(ctx context.Context, book AddressBook) error {
+	due, err := dueNotifications(ctx, s.cache, book, date.Today())
+	if err != nil {
+		return err
+	}
+	for _, d := range due {
+		notifier, ok := s.notifiers[d.Ref.Notifier]
+		if !ok {
+			s.logger.Warn().Str("addressBook", book.Name).Str("notifier", d.Ref.Notifier).Msg("unknown notifier")
+			continue
+		}
+		if err := notifier.Notify(ctx, d.Notification); err != nil {
+			s.logger.Warn().Str("addressBook", book.Name).Str("notifier", d.Ref.Notifier).Err(err).Msg("failed to send notification")
+		}
+	}
+	return nil
+}
+
+type dueNotification struct {
+	Ref          NotificationRef
+	Notification Notification
+}
+
+// dueNotifications computes, for every birthday in book and every offset in
+// book.Notifications, whether today is exactly that many days before the
+// next occurrence of that birthday.
+func dueNotifications(ctx context.Context, cache *BirthdayCache, book AddressBook, today date.Date) ([]dueNotification, error) {
+	birthdays, err := cache.Birthdays(ctx, book)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get birthdays: %w", err)
+	}
+
+	var due []dueNotification
+	for _, birthday := range birthdays {
+		notification := upcomingNotification(birthday, today)
+		for _, ref := range book.Notifications {
+			for _, offset := range ref.Offsets {
+				if offset == notification.DaysUntil {
+					due = append(due, dueNotification{Ref: ref, Notification: notification})
+				}
+			}
+		}
+	}
+	return due, nil
+}
+
+func upcomingNotification(birthday Birthday, today date.Date) Notification {
+	todayTime := today.ToStdTime()
+	month, day := birthday.Date.ToStdTime().Month(), birthday.Date.ToStdTime().Day()
+
+	occurs := time.Date(todayTime.Year(), month, day, 0, 0, 0, 0, time.UTC)
+	if occurs.Before(todayTime) {
+		occurs = occurs.AddDate(1, 0, 0)
+	}
+	daysUntil := int(occurs.Sub(todayTime).Hours() / 24)
+
+	notification := Notification{
+		Name:      birthday.FullName,
+		Date:      date.FromTime(occurs),
+		DaysUntil: daysUntil,
+	}
+	if birthday.HasYear {
+		notification.Age = occurs.Year() - birthday.Date.ToStdTime().Year()
+		notification.HasAge = true
+	}
+	return notification
+}
+
+// PreviewNotificationsHandler is an admin endpoint, gated by the same API
+// key as the ICS feed, that renders what notifications would be sent today
+// for a given address book without actually sending them.
+func PreviewNotificationsHandler(addressBooks AddressBooks, cache *BirthdayCache, apiKey string) http.Handler {
+	return srvu.ErrHandlerFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if r.FormValue("apiKey") != apiKey {
+			return srvu.Err(http.StatusUnauthorized, fmt.Errorf("invalid api key"))
+		}
+		name := r.FormValue("addressBook")
+		book, ok := addressBooks[name]
+		if !ok {
+			return srvu.Err(http.StatusNotFound, fmt.Errorf("address book %s not found", name))
+		}
+
+		due, err := dueNotifications(ctx, cache, book, date.Today())
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		return json.NewEncoder(w).Encode(due)
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SimonSchneider/goslu/date"
+)
+
+func TestUpcomingNotification(t *testing.T) {
+	today := date.FromTime(time.Date(2026, time.March, 10, 0, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name          string
+		birthday      Birthday
+		wantDaysUntil int
+		wantHasAge    bool
+		wantAge       int
+	}{
+		{
+			name:          "birthday today",
+			birthday:      Birthday{FullName: "Ada", Date: date.FromTime(time.Date(1990, time.March, 10, 0, 0, 0, 0, time.UTC)), HasYear: true},
+			wantDaysUntil: 0,
+			wantHasAge:    true,
+			wantAge:       36,
+		},
+		{
+			name:          "birthday later this year",
+			birthday:      Birthday{FullName: "Bob", Date: date.FromTime(time.Date(1990, time.March, 15, 0, 0, 0, 0, time.UTC)), HasYear: true},
+			wantDaysUntil: 5,
+			wantHasAge:    true,
+			wantAge:       36,
+		},
+		{
+			name:          "birthday already passed this year rolls over to next year",
+			birthday:      Birthday{FullName: "Cara", Date: date.FromTime(time.Date(1990, time.January, 1, 0, 0, 0, 0, time.UTC)), HasYear: true},
+			wantDaysUntil: 297,
+			wantHasAge:    true,
+			wantAge:       37,
+		},
+		{
+			name:          "year-unknown birthday has no age",
+			birthday:      Birthday{FullName: "Dee", Date: date.FromTime(time.Date(noYearPlaceholder, time.March, 15, 0, 0, 0, 0, time.UTC)), HasYear: false},
+			wantDaysUntil: 5,
+			wantHasAge:    false,
+		},
+		{
+			name:          "leap day birthday in a non-leap year rolls to the next occurrence",
+			birthday:      Birthday{FullName: "Leap", Date: date.FromTime(time.Date(1992, time.February, 29, 0, 0, 0, 0, time.UTC)), HasYear: true},
+			wantDaysUntil: 356,
+			wantHasAge:    true,
+			wantAge:       35,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := upcomingNotification(tt.birthday, today)
+			if got.DaysUntil != tt.wantDaysUntil {
+				t.Errorf("DaysUntil = %d, want %d", got.DaysUntil, tt.wantDaysUntil)
+			}
+			if got.HasAge != tt.wantHasAge {
+				t.Errorf("HasAge = %v, want %v", got.HasAge, tt.wantHasAge)
+			}
+			if tt.wantHasAge && got.Age != tt.wantAge {
+				t.Errorf("Age = %d, want %d", got.Age, tt.wantAge)
+			}
+		})
+	}
+}
+
+func TestSanitizeHeaderValue(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "plain name is unchanged",
+			in:   "Ada Lovelace",
+			want: "Ada Lovelace",
+		},
+		{
+			name: "CRLF header injection is stripped",
+			in:   "Ada\r\nBcc: attacker@example.com",
+			want: "AdaBcc: attacker@example.com",
+		},
+		{
+			name: "bare LF is stripped",
+			in:   "Ada\nX-Injected: true",
+			want: "AdaX-Injected: true",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeHeaderValue(tt.in); got != tt.want {
+				t.Errorf("sanitizeHeaderValue(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}